@@ -4,23 +4,99 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/pubsub"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	structpb "github.com/golang/protobuf/ptypes/struct"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/api/option"
 
+	pb "github.com/brocaar/chirpstack-api/go/as/integration"
 	"github.com/brocaar/chirpstack-application-server/internal/integration"
 	"github.com/brocaar/chirpstack-application-server/internal/logging"
 	"github.com/brocaar/lorawan"
 )
 
+// PublishSettings holds the batching settings used for publishing
+// messages to the Pub/Sub topic. See the underlying pubsub.PublishSettings
+// for the semantics of each field.
+type PublishSettings struct {
+	CountThreshold int           `mapstructure:"count_threshold"`
+	ByteThreshold  int           `mapstructure:"byte_threshold"`
+	DelayThreshold time.Duration `mapstructure:"delay_threshold"`
+	NumGoroutines  int           `mapstructure:"num_goroutines"`
+}
+
 // Config holds the GCP Pub/Sub integration configuration.
 type Config struct {
 	CredentialsFile string `mapstructure:"credentials_file"`
 	ProjectID       string `mapstructure:"project_id"`
 	TopicName       string `mapstructure:"topic_name"`
+
+	// EnableMessageOrdering, when set, guarantees that uplink and ACK
+	// events for a single device are delivered to subscribers in the
+	// order they were published.
+	EnableMessageOrdering bool `mapstructure:"enable_message_ordering"`
+
+	// PublishSettings overrides the batching settings of the Pub/Sub
+	// client library.
+	PublishSettings PublishSettings `mapstructure:"publish_settings"`
+
+	// Marshaler defines how events are encoded onto the Pub/Sub message
+	// body: "json" (default), "protobuf" or "json_v3". The last two
+	// encode the ChirpStack as/integration event schema instead of the
+	// legacy free-form JSON, so that subscribers can decode without
+	// sniffing the payload.
+	Marshaler string `mapstructure:"marshaler"`
+
+	// DownlinkSubscriptionName, when set, enables scheduling of downlink
+	// payloads through a Pub/Sub subscription.
+	DownlinkSubscriptionName string `mapstructure:"downlink_subscription_name"`
+
+	// DownlinkTopicName is optional. When the subscription configured
+	// above does not exist yet, it is created for this topic.
+	DownlinkTopicName string `mapstructure:"downlink_topic_name"`
+
+	// DownlinkAckDeadlineExtension configures for how long the client
+	// keeps extending the ack deadline of a downlink message while it is
+	// waiting to be delivered on the DataDownChan. When not set, the
+	// Pub/Sub client default is used.
+	DownlinkAckDeadlineExtension time.Duration `mapstructure:"downlink_ack_deadline_extension"`
+
+	// DownlinkBufferSize sets the buffer size of the channel returned by
+	// DataDownChan. When not set, defaultDownlinkBufferSize is used.
+	DownlinkBufferSize int `mapstructure:"downlink_buffer_size"`
+}
+
+// defaultPublishResultWorkers is used when PublishSettings.NumGoroutines
+// is not set.
+const defaultPublishResultWorkers = 10
+
+// defaultPublishErrorBufferSize is the buffer size of the channel
+// returned by PublishErrors.
+const defaultPublishErrorBufferSize = 100
+
+// defaultDownlinkBufferSize is used when Config.DownlinkBufferSize is
+// not set.
+const defaultDownlinkBufferSize = 100
+
+// PublishError is reported on the PublishErrors channel when a message
+// handed off by SendDataUp (or one of the other Send* methods) fails to
+// reach Pub/Sub. It surfaces asynchronously, since the actual publish
+// result is awaited by the worker pool rather than by the Send* caller.
+type PublishError struct {
+	Event  string
+	DevEUI lorawan.EUI64
+	Err    error
+}
+
+func (e PublishError) Error() string {
+	return fmt.Sprintf("gcppubsub: publish %s event for %s failed: %s", e.Event, e.DevEUI, e.Err)
 }
 
 // Integration implements a GCP Pub/Sub integration.
@@ -28,9 +104,30 @@ type Integration struct {
 	sync.RWMutex
 	ctx    context.Context
 	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	client    *pubsub.Client
+	topic     *pubsub.Topic
+	marshaler Marshaler
+
+	resultChan chan publishResult
+	resultWG   sync.WaitGroup
+	closed     bool
+	errChan    chan PublishError
 
-	client *pubsub.Client
-	topic  *pubsub.Topic
+	downlinkSub  *pubsub.Subscription
+	downlinkChan chan integration.DataDownPayload
+}
+
+// publishResult bundles a pending pubsub.PublishResult with the context
+// needed to log it and, on ordered-publish errors, resume the topic for
+// the affected ordering key.
+type publishResult struct {
+	res         *pubsub.PublishResult
+	devEUI      lorawan.EUI64
+	event       string
+	orderingKey string
+	ctxID       interface{}
 }
 
 // New creates a new Pub/Sub integration.
@@ -43,6 +140,17 @@ func New(conf Config) (*Integration, error) {
 
 	i.ctx, i.cancel = context.WithCancel(i.ctx)
 
+	switch conf.Marshaler {
+	case "", "json":
+		i.marshaler = &jsonMarshaler{}
+	case "protobuf":
+		i.marshaler = &protobufMarshaler{}
+	case "json_v3":
+		i.marshaler = &jsonV3Marshaler{}
+	default:
+		return nil, fmt.Errorf("gcppubsub: unknown marshaler: %s", conf.Marshaler)
+	}
+
 	if conf.CredentialsFile != "" {
 		o = append(o, option.WithCredentialsFile(conf.CredentialsFile))
 	}
@@ -63,16 +171,224 @@ func New(conf Config) (*Integration, error) {
 		return nil, fmt.Errorf("topic %s does not exist", conf.TopicName)
 	}
 
+	if conf.EnableMessageOrdering {
+		i.topic.EnableMessageOrdering = true
+	}
+	if conf.PublishSettings.CountThreshold != 0 {
+		i.topic.PublishSettings.CountThreshold = conf.PublishSettings.CountThreshold
+	}
+	if conf.PublishSettings.ByteThreshold != 0 {
+		i.topic.PublishSettings.ByteThreshold = conf.PublishSettings.ByteThreshold
+	}
+	if conf.PublishSettings.DelayThreshold != 0 {
+		i.topic.PublishSettings.DelayThreshold = conf.PublishSettings.DelayThreshold
+	}
+	if conf.PublishSettings.NumGoroutines != 0 {
+		i.topic.PublishSettings.NumGoroutines = conf.PublishSettings.NumGoroutines
+	}
+
+	numResultWorkers := conf.PublishSettings.NumGoroutines
+	if numResultWorkers <= 0 {
+		numResultWorkers = defaultPublishResultWorkers
+	}
+	i.resultChan = make(chan publishResult, numResultWorkers*10)
+	i.errChan = make(chan PublishError, defaultPublishErrorBufferSize)
+	for n := 0; n < numResultWorkers; n++ {
+		i.resultWG.Add(1)
+		go i.publishResultWorker()
+	}
+
+	if conf.DownlinkSubscriptionName != "" {
+		if err := i.setupDownlinkSubscription(conf); err != nil {
+			return nil, errors.Wrap(err, "setup downlink subscription error")
+		}
+	}
+
 	return &i, nil
 }
 
+// setupDownlinkSubscription resolves (or creates) the downlink
+// subscription and starts the goroutine that pulls downlink payloads from
+// it onto downlinkChan.
+func (i *Integration) setupDownlinkSubscription(conf Config) error {
+	log.WithField("subscription", conf.DownlinkSubscriptionName).Info("integration/gcppubsub: setup downlink subscription")
+	i.downlinkSub = i.client.Subscription(conf.DownlinkSubscriptionName)
+
+	ok, err := i.downlinkSub.Exists(i.ctx)
+	if err != nil {
+		return errors.Wrap(err, "subscription exists error")
+	}
+	if !ok {
+		if conf.DownlinkTopicName == "" {
+			return fmt.Errorf("subscription %s does not exist", conf.DownlinkSubscriptionName)
+		}
+
+		downlinkTopic := i.client.Topic(conf.DownlinkTopicName)
+		topicOk, err := downlinkTopic.Exists(i.ctx)
+		if err != nil {
+			return errors.Wrap(err, "downlink topic exists error")
+		}
+		if !topicOk {
+			return fmt.Errorf("topic %s does not exist", conf.DownlinkTopicName)
+		}
+
+		i.downlinkSub, err = i.client.CreateSubscription(i.ctx, conf.DownlinkSubscriptionName, pubsub.SubscriptionConfig{
+			Topic: downlinkTopic,
+		})
+		if err != nil {
+			return errors.Wrap(err, "create subscription error")
+		}
+	}
+
+	if conf.DownlinkAckDeadlineExtension != 0 {
+		i.downlinkSub.ReceiveSettings.MaxExtension = conf.DownlinkAckDeadlineExtension
+	}
+
+	bufSize := conf.DownlinkBufferSize
+	if bufSize <= 0 {
+		bufSize = defaultDownlinkBufferSize
+	}
+	i.downlinkChan = make(chan integration.DataDownPayload, bufSize)
+
+	i.wg.Add(1)
+	go func() {
+		defer i.wg.Done()
+		if err := i.downlinkSub.Receive(i.ctx, i.handleDownlinkMessage); err != nil && i.ctx.Err() == nil {
+			log.WithError(err).Error("integration/gcppubsub: downlink subscription receive error")
+		}
+	}()
+
+	return nil
+}
+
+// handleDownlinkMessage unmarshals the given Pub/Sub message into a
+// DataDownPayload and forwards it onto downlinkChan. It only acks the
+// message once the payload has been handed off, so that a restart before
+// delivery results in redelivery instead of a lost downlink.
+func (i *Integration) handleDownlinkMessage(ctx context.Context, msg *pubsub.Message) {
+	var pl integration.DataDownPayload
+	if err := json.Unmarshal(msg.Data, &pl); err != nil {
+		log.WithError(err).Error("integration/gcppubsub: unmarshal downlink payload error")
+		msg.Nack()
+		return
+	}
+
+	if err := applyDownlinkAttributes(&pl, msg.Attributes); err != nil {
+		log.WithError(err).Error("integration/gcppubsub: apply downlink attributes error")
+		msg.Nack()
+		return
+	}
+
+	select {
+	case i.downlinkChan <- pl:
+		msg.Ack()
+	case <-i.ctx.Done():
+		msg.Nack()
+	}
+}
+
+// applyDownlinkAttributes overrides the devEUI / reference / confirmed /
+// fPort fields of pl with the given Pub/Sub message attributes, when
+// present.
+func applyDownlinkAttributes(pl *integration.DataDownPayload, attrs map[string]string) error {
+	if devEUI, ok := attrs["devEUI"]; ok {
+		if err := pl.DevEUI.UnmarshalText([]byte(devEUI)); err != nil {
+			return errors.Wrap(err, "unmarshal devEUI attribute error")
+		}
+	}
+
+	if reference, ok := attrs["reference"]; ok {
+		pl.Reference = reference
+	}
+
+	if confirmed, ok := attrs["confirmed"]; ok {
+		b, err := strconv.ParseBool(confirmed)
+		if err != nil {
+			return errors.Wrap(err, "parse confirmed attribute error")
+		}
+		pl.Confirmed = b
+	}
+
+	if fPort, ok := attrs["fPort"]; ok {
+		n, err := strconv.ParseUint(fPort, 10, 8)
+		if err != nil {
+			return errors.Wrap(err, "parse fPort attribute error")
+		}
+		pl.FPort = uint8(n)
+	}
+
+	return nil
+}
+
 // Close closes the integration.
 func (i *Integration) Close() error {
 	log.Info("integration/gcppubsub: closing integration")
 	i.cancel()
+	i.wg.Wait()
+
+	if i.downlinkChan != nil {
+		close(i.downlinkChan)
+	}
+
+	// Taking the write lock blocks until every publish() call that is
+	// already past the read lock (and therefore committed to sending on
+	// resultChan) has returned, and marks the integration closed so
+	// that any publish() racing with Close bails out before it can send
+	// on a channel we are about to close.
+	i.Lock()
+	i.closed = true
+	i.Unlock()
+	close(i.resultChan)
+	i.resultWG.Wait()
+	close(i.errChan)
+
 	return i.client.Close()
 }
 
+// PublishErrors returns the channel on which asynchronous publish
+// failures (e.g. a Pub/Sub outage or a deleted topic) are reported,
+// since the worker pool awaits the publish result after SendDataUp (and
+// the other Send* methods) has already returned. Reading from this
+// channel is optional; every error is logged regardless.
+func (i *Integration) PublishErrors() <-chan PublishError {
+	return i.errChan
+}
+
+// publishResultWorker waits for the outcome of published messages without
+// blocking the goroutine that called publish. On an ordered-publish
+// error, it resumes the topic for the affected ordering key so that
+// subsequent events for that device are not silently dropped.
+func (i *Integration) publishResultWorker() {
+	defer i.resultWG.Done()
+
+	for pr := range i.resultChan {
+		if _, err := pr.res.Get(i.ctx); err != nil {
+			if pr.orderingKey != "" {
+				i.topic.ResumePublish(pr.orderingKey)
+			}
+
+			log.WithFields(log.Fields{
+				"dev_eui": pr.devEUI,
+				"event":   pr.event,
+				"ctx_id":  pr.ctxID,
+			}).WithError(err).Error("integration/gcppubsub: get publish result error")
+
+			select {
+			case i.errChan <- PublishError{Event: pr.event, DevEUI: pr.devEUI, Err: err}:
+			default:
+				log.Warn("integration/gcppubsub: publish error channel is full, dropping error")
+			}
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"dev_eui": pr.devEUI,
+			"event":   pr.event,
+			"ctx_id":  pr.ctxID,
+		}).Info("integration/gcppubsub: event published")
+	}
+}
+
 // SendDataUp sends an uplink data payload.
 func (i *Integration) SendDataUp(ctx context.Context, pl integration.DataUpPayload) error {
 	return i.publish(ctx, "up", pl.DevEUI, pl)
@@ -103,33 +419,279 @@ func (i *Integration) SendLocationNotification(ctx context.Context, pl integrati
 	return i.publish(ctx, "location", pl.DevEUI, pl)
 }
 
-// DataDownChan return nil.
+// DataDownChan returns the channel on which downlink payloads are
+// delivered. It returns nil when no downlink subscription was configured.
 func (i *Integration) DataDownChan() chan integration.DataDownPayload {
-	return nil
+	return i.downlinkChan
 }
 
 func (i *Integration) publish(ctx context.Context, event string, devEUI lorawan.EUI64, v interface{}) error {
-	jsonB, err := json.Marshal(v)
+	// Held for the whole marshal + publish + hand-off sequence so that
+	// Close, which takes the write lock before closing resultChan, can
+	// never run concurrently with a publish that is about to send on it.
+	i.RLock()
+	defer i.RUnlock()
+	if i.closed {
+		return errors.New("gcppubsub: integration is closed")
+	}
+
+	b, contentType, err := i.marshaler.Marshal(event, v)
 	if err != nil {
-		return errors.Wrap(err, "marshal json error")
+		return errors.Wrap(err, "marshal payload error")
 	}
 
-	res := i.topic.Publish(ctx, &pubsub.Message{
-		Data: jsonB,
+	msg := pubsub.Message{
+		Data: b,
 		Attributes: map[string]string{
-			"event":  event,
-			"devEUI": devEUI.String(),
+			"event":        event,
+			"devEUI":       devEUI.String(),
+			"content-type": contentType,
 		},
-	})
-	if _, err := res.Get(i.ctx); err != nil {
-		return errors.Wrap(err, "get publish result error")
 	}
 
-	log.WithFields(log.Fields{
-		"dev_eui": devEUI,
-		"event":   event,
-		"ctx_id":  ctx.Value(logging.ContextIDKey),
-	}).Info("integration/gcppubsub: event published")
+	orderingKey := orderingKeyFor(i.topic.EnableMessageOrdering, devEUI)
+	if orderingKey != "" {
+		msg.OrderingKey = orderingKey
+	}
+
+	res := i.topic.Publish(ctx, &msg)
+
+	// The result is handed off to the worker pool so that publishing
+	// many events (e.g. for a gateway with many devices) does not block
+	// on an ack round-trip per message. The read lock held above
+	// guarantees resultChan is still open at this point.
+	i.resultChan <- publishResult{
+		res:         res,
+		devEUI:      devEUI,
+		event:       event,
+		orderingKey: orderingKey,
+		ctxID:       ctx.Value(logging.ContextIDKey),
+	}
 
 	return nil
 }
+
+// orderingKeyFor returns the Pub/Sub ordering key to use for a publish of
+// the given devEUI, or an empty string when ordering is disabled. Keying
+// by devEUI guarantees per-device message ordering without forcing a
+// single ordering key (and thus a single publish stream) for the whole
+// topic.
+func orderingKeyFor(enabled bool, devEUI lorawan.EUI64) string {
+	if !enabled {
+		return ""
+	}
+	return devEUI.String()
+}
+
+// Marshaler encodes an event payload onto the Pub/Sub message body and
+// reports the Pub/Sub "content-type" attribute value that describes it.
+type Marshaler interface {
+	Marshal(event string, v interface{}) ([]byte, string, error)
+}
+
+// jsonMarshaler encodes the free-form integration.*Payload /
+// *Notification structs as-is. This is the default and matches the
+// behavior ChirpStack has always used for this integration.
+type jsonMarshaler struct{}
+
+func (m *jsonMarshaler) Marshal(event string, v interface{}) ([]byte, string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "marshal json error")
+	}
+	return b, "application/json", nil
+}
+
+// protobufMarshaler encodes events as the ChirpStack as/integration
+// protobuf messages, so that downstream consumers (e.g. Dataflow or
+// BigQuery pipelines) can decode against a stable schema.
+type protobufMarshaler struct{}
+
+func (m *protobufMarshaler) Marshal(event string, v interface{}) ([]byte, string, error) {
+	msg, err := eventToProto(event, v)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "marshal protobuf error")
+	}
+	return b, "application/protobuf", nil
+}
+
+// jsonV3Marshaler encodes the same as/integration protobuf messages as
+// protobufMarshaler, but as JSON (using the protobuf JSON mapping)
+// instead of the binary wire format.
+type jsonV3Marshaler struct{}
+
+func (m *jsonV3Marshaler) Marshal(event string, v interface{}) ([]byte, string, error) {
+	msg, err := eventToProto(event, v)
+	if err != nil {
+		return nil, "", err
+	}
+
+	marshaler := jsonpb.Marshaler{}
+	str, err := marshaler.MarshalToString(msg)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "marshal json (v3 schema) error")
+	}
+	return []byte(str), "application/json", nil
+}
+
+// eventToProto translates the given integration event into its
+// as/integration protobuf representation.
+func eventToProto(event string, v interface{}) (proto.Message, error) {
+	switch pl := v.(type) {
+	case integration.DataUpPayload:
+		obj, err := structToProto(pl.Object)
+		if err != nil {
+			return nil, errors.Wrap(err, "object to protobuf struct error")
+		}
+
+		rxInfo := make([]*pb.UplinkRXInfo, len(pl.RXInfo))
+		for idx, rx := range pl.RXInfo {
+			rxInfo[idx] = &pb.UplinkRXInfo{
+				Rssi:    int32(rx.RSSI),
+				LoraSnr: float32(rx.LoRaSNR),
+			}
+		}
+
+		return &pb.UplinkEvent{
+			ApplicationName: pl.ApplicationName,
+			DeviceName:      pl.DeviceName,
+			DevEui:          pl.DevEUI[:],
+			Tags:            pl.Tags,
+			FCnt:            pl.FCnt,
+			FPort:           uint32(pl.FPort),
+			Data:            pl.Data,
+			Object:          obj,
+			TxInfo: &pb.UplinkTXInfo{
+				Frequency: uint32(pl.TXInfo.Frequency),
+				Dr:        uint32(pl.TXInfo.DR),
+			},
+			RxInfo: rxInfo,
+		}, nil
+
+	case integration.JoinNotification:
+		return &pb.JoinEvent{
+			ApplicationName: pl.ApplicationName,
+			DeviceName:      pl.DeviceName,
+			DevEui:          pl.DevEUI[:],
+			DevAddr:         pl.DevAddr[:],
+			Tags:            pl.Tags,
+		}, nil
+
+	case integration.ACKNotification:
+		return &pb.AckEvent{
+			ApplicationName: pl.ApplicationName,
+			DeviceName:      pl.DeviceName,
+			DevEui:          pl.DevEUI[:],
+			Acknowledged:    pl.Acknowledged,
+			FCnt:            pl.FCnt,
+			Tags:            pl.Tags,
+		}, nil
+
+	case integration.ErrorNotification:
+		return &pb.ErrorEvent{
+			ApplicationName: pl.ApplicationName,
+			DeviceName:      pl.DeviceName,
+			DevEui:          pl.DevEUI[:],
+			Type:            pl.Type,
+			Error:           pl.Error,
+			FCnt:            pl.FCnt,
+			Tags:            pl.Tags,
+		}, nil
+
+	case integration.StatusNotification:
+		return &pb.StatusEvent{
+			ApplicationName: pl.ApplicationName,
+			DeviceName:      pl.DeviceName,
+			DevEui:          pl.DevEUI[:],
+			Margin:          int32(pl.Margin),
+			Battery:         pl.Battery,
+			BatteryLevel:    float32(pl.BatteryLevel),
+			Tags:            pl.Tags,
+		}, nil
+
+	case integration.LocationNotification:
+		return &pb.LocationEvent{
+			ApplicationName: pl.ApplicationName,
+			DeviceName:      pl.DeviceName,
+			DevEui:          pl.DevEUI[:],
+			Tags:            pl.Tags,
+			Location: &pb.Location{
+				Latitude:  pl.Location.Latitude,
+				Longitude: pl.Location.Longitude,
+				Altitude:  pl.Location.Altitude,
+			},
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("gcppubsub: no protobuf mapping for event %q", event)
+	}
+}
+
+// structToProto converts the decoded uplink Object (either a
+// map[string]interface{} or a concrete codec type) into a
+// structpb.Struct by round-tripping it through JSON.
+func structToProto(object interface{}) (*structpb.Struct, error) {
+	if object == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(object)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal object error")
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrap(err, "unmarshal object error")
+	}
+
+	return structFromMap(m)
+}
+
+func structFromMap(m map[string]interface{}) (*structpb.Struct, error) {
+	fields := make(map[string]*structpb.Value, len(m))
+	for k, v := range m {
+		val, err := structValue(v)
+		if err != nil {
+			return nil, err
+		}
+		fields[k] = val
+	}
+	return &structpb.Struct{Fields: fields}, nil
+}
+
+func structValue(v interface{}) (*structpb.Value, error) {
+	switch val := v.(type) {
+	case nil:
+		return &structpb.Value{Kind: &structpb.Value_NullValue{}}, nil
+	case bool:
+		return &structpb.Value{Kind: &structpb.Value_BoolValue{BoolValue: val}}, nil
+	case float64:
+		return &structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: val}}, nil
+	case string:
+		return &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: val}}, nil
+	case map[string]interface{}:
+		st, err := structFromMap(val)
+		if err != nil {
+			return nil, err
+		}
+		return &structpb.Value{Kind: &structpb.Value_StructValue{StructValue: st}}, nil
+	case []interface{}:
+		values := make([]*structpb.Value, len(val))
+		for idx, item := range val {
+			iv, err := structValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values[idx] = iv
+		}
+		return &structpb.Value{Kind: &structpb.Value_ListValue{ListValue: &structpb.ListValue{Values: values}}}, nil
+	default:
+		return nil, fmt.Errorf("gcppubsub: unsupported object value type %T", v)
+	}
+}
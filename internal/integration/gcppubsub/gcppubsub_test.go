@@ -0,0 +1,244 @@
+package gcppubsub
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/brocaar/chirpstack-api/go/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration"
+	"github.com/brocaar/lorawan"
+)
+
+func TestApplyDownlinkAttributes(t *testing.T) {
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	tests := []struct {
+		Name       string
+		Attrs      map[string]string
+		ExpPayload integration.DataDownPayload
+		ExpError   string
+	}{
+		{
+			Name:       "no attributes",
+			Attrs:      map[string]string{},
+			ExpPayload: integration.DataDownPayload{},
+		},
+		{
+			Name: "all attributes set",
+			Attrs: map[string]string{
+				"devEUI":    devEUI.String(),
+				"reference": "test-reference",
+				"confirmed": "true",
+				"fPort":     "10",
+			},
+			ExpPayload: integration.DataDownPayload{
+				DevEUI:    devEUI,
+				Reference: "test-reference",
+				Confirmed: true,
+				FPort:     10,
+			},
+		},
+		{
+			Name: "invalid devEUI",
+			Attrs: map[string]string{
+				"devEUI": "not-a-dev-eui",
+			},
+			ExpError: "unmarshal devEUI attribute error: encoding/hex: invalid byte: U+006E 'n'",
+		},
+		{
+			Name: "invalid confirmed",
+			Attrs: map[string]string{
+				"confirmed": "not-a-bool",
+			},
+			ExpError: "parse confirmed attribute error: strconv.ParseBool: parsing \"not-a-bool\": invalid syntax",
+		},
+		{
+			Name: "invalid fPort",
+			Attrs: map[string]string{
+				"fPort": "not-a-uint",
+			},
+			ExpError: "parse fPort attribute error: strconv.ParseUint: parsing \"not-a-uint\": invalid syntax",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var pl integration.DataDownPayload
+			err := applyDownlinkAttributes(&pl, tst.Attrs)
+
+			if tst.ExpError != "" {
+				assert.EqualError(err, tst.ExpError)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tst.ExpPayload, pl)
+		})
+	}
+}
+
+func TestOrderingKeyFor(t *testing.T) {
+	assert := require.New(t)
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	assert.Equal("", orderingKeyFor(false, devEUI))
+	assert.Equal(devEUI.String(), orderingKeyFor(true, devEUI))
+}
+
+func TestPublishErrorError(t *testing.T) {
+	assert := require.New(t)
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	pe := PublishError{
+		Event:  "up",
+		DevEUI: devEUI,
+		Err:    errors.New("context deadline exceeded"),
+	}
+
+	assert.Equal(
+		"gcppubsub: publish up event for 0102030405060708 failed: context deadline exceeded",
+		pe.Error(),
+	)
+}
+
+func TestStructToProto(t *testing.T) {
+	t.Run("nil object", func(t *testing.T) {
+		assert := require.New(t)
+
+		st, err := structToProto(nil)
+		assert.NoError(err)
+		assert.Nil(st)
+	})
+
+	t.Run("map object", func(t *testing.T) {
+		assert := require.New(t)
+
+		st, err := structToProto(map[string]interface{}{
+			"temperature": 21.5,
+			"humidity":    60,
+			"valid":       true,
+			"label":       "sensor-1",
+			"location":    nil,
+			"tags":        []interface{}{"a", "b"},
+			"nested":      map[string]interface{}{"foo": "bar"},
+		})
+		assert.NoError(err)
+
+		assert.Equal(21.5, st.Fields["temperature"].GetNumberValue())
+		assert.Equal(float64(60), st.Fields["humidity"].GetNumberValue())
+		assert.Equal(true, st.Fields["valid"].GetBoolValue())
+		assert.Equal("sensor-1", st.Fields["label"].GetStringValue())
+		assert.NotNil(st.Fields["location"].GetNullValue())
+		assert.Equal("bar", st.Fields["nested"].GetStructValue().Fields["foo"].GetStringValue())
+
+		tags := st.Fields["tags"].GetListValue().Values
+		assert.Len(tags, 2)
+		assert.Equal("a", tags[0].GetStringValue())
+		assert.Equal("b", tags[1].GetStringValue())
+	})
+
+	t.Run("unsupported value type", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := structValue(make(chan int))
+		assert.Error(err)
+	})
+}
+
+func TestEventToProto(t *testing.T) {
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	devAddr := lorawan.DevAddr{1, 2, 3, 4}
+
+	t.Run("uplink", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := integration.DataUpPayload{
+			ApplicationName: "test-app",
+			DeviceName:      "test-device",
+			DevEUI:          devEUI,
+			FCnt:            10,
+			FPort:           1,
+			Data:            []byte{1, 2, 3},
+			Object:          map[string]interface{}{"temperature": 21.5},
+			TXInfo: integration.TXInfo{
+				Frequency: 868100000,
+				DR:        5,
+			},
+			RXInfo: []integration.RXInfo{
+				{RSSI: -55, LoRaSNR: 7.5},
+			},
+		}
+
+		msg, err := eventToProto("up", pl)
+		assert.NoError(err)
+
+		up, ok := msg.(*pb.UplinkEvent)
+		assert.True(ok)
+		assert.Equal("test-app", up.ApplicationName)
+		assert.Equal("test-device", up.DeviceName)
+		assert.Equal(devEUI[:], up.DevEui)
+		assert.Equal(uint32(10), up.FCnt)
+		assert.Equal(uint32(1), up.FPort)
+		assert.Equal([]byte{1, 2, 3}, up.Data)
+		assert.Equal(21.5, up.Object.Fields["temperature"].GetNumberValue())
+		assert.Equal(uint32(868100000), up.TxInfo.Frequency)
+		assert.Equal(uint32(5), up.TxInfo.Dr)
+		assert.Len(up.RxInfo, 1)
+		assert.Equal(int32(-55), up.RxInfo[0].Rssi)
+		assert.Equal(float32(7.5), up.RxInfo[0].LoraSnr)
+	})
+
+	t.Run("location", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := integration.LocationNotification{
+			ApplicationName: "test-app",
+			DeviceName:      "test-device",
+			DevEUI:          devEUI,
+			Location: integration.Location{
+				Latitude:  1.123,
+				Longitude: 2.123,
+				Altitude:  3.5,
+			},
+		}
+
+		msg, err := eventToProto("location", pl)
+		assert.NoError(err)
+
+		loc, ok := msg.(*pb.LocationEvent)
+		assert.True(ok)
+		assert.NotNil(loc.Location)
+		assert.Equal(1.123, loc.Location.Latitude)
+		assert.Equal(2.123, loc.Location.Longitude)
+		assert.Equal(3.5, loc.Location.Altitude)
+	})
+
+	t.Run("join", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl := integration.JoinNotification{
+			ApplicationName: "test-app",
+			DeviceName:      "test-device",
+			DevEUI:          devEUI,
+			DevAddr:         devAddr,
+		}
+
+		msg, err := eventToProto("join", pl)
+		assert.NoError(err)
+
+		join, ok := msg.(*pb.JoinEvent)
+		assert.True(ok)
+		assert.Equal(devAddr[:], join.DevAddr)
+	})
+
+	t.Run("unsupported event", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := eventToProto("unknown", struct{}{})
+		assert.Error(err)
+	})
+}
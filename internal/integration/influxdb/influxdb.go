@@ -0,0 +1,442 @@
+// Package influxdb implements an InfluxDB integration.
+package influxdb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mmcloughlin/geohash"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-application-server/internal/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/logging"
+	"github.com/brocaar/lorawan"
+)
+
+// Config holds the InfluxDB integration configuration.
+type Config struct {
+	// Version of the InfluxDB API to write to ("1" or "2"). Defaults to
+	// "1" when not set.
+	Version string `mapstructure:"version"`
+
+	Endpoint string `mapstructure:"endpoint"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+
+	// DB and RetentionPolicyName are only used when Version is "1".
+	DB                  string `mapstructure:"db"`
+	RetentionPolicyName string `mapstructure:"retention_policy_name"`
+
+	// Organization, Bucket and Token are only used when Version is "2".
+	Organization string `mapstructure:"organization"`
+	Bucket       string `mapstructure:"bucket"`
+	Token        string `mapstructure:"token"`
+
+	Precision string `mapstructure:"precision"`
+}
+
+// Integration implements an InfluxDB integration.
+type Integration struct {
+	config     Config
+	httpClient *http.Client
+}
+
+// New creates a new InfluxDB integration.
+func New(conf Config) (*Integration, error) {
+	if conf.Version == "" {
+		conf.Version = "1"
+	}
+	if conf.Version != "1" && conf.Version != "2" {
+		return nil, fmt.Errorf("influxdb: version must be '1' or '2', got: %s", conf.Version)
+	}
+
+	return &Integration{
+		config:     conf,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// Close closes the handler.
+func (i *Integration) Close() error {
+	return nil
+}
+
+// SendDataUp sends an uplink data payload.
+func (i *Integration) SendDataUp(ctx context.Context, pl integration.DataUpPayload) error {
+	tags := baseTags(pl.ApplicationName, pl.DeviceName, pl.DevEUI, pl.Tags)
+
+	uplinkTags := copyTags(tags)
+	uplinkTags["dr"] = strconv.Itoa(pl.TXInfo.DR)
+	uplinkTags["frequency"] = strconv.Itoa(pl.TXInfo.Frequency)
+
+	uplinkFields := map[string]interface{}{
+		"f_cnt": pl.FCnt,
+		"value": 1,
+	}
+
+	if len(pl.RXInfo) != 0 {
+		best := pl.RXInfo[0]
+		for _, rx := range pl.RXInfo[1:] {
+			if rx.LoRaSNR > best.LoRaSNR {
+				best = rx
+			}
+		}
+		uplinkFields["rssi"] = best.RSSI
+		uplinkFields["snr"] = best.LoRaSNR
+	}
+
+	lines := []string{buildLine("device_uplink", uplinkTags, uplinkFields)}
+
+	payloadTags := copyTags(tags)
+	payloadTags["f_port"] = strconv.Itoa(int(pl.FPort))
+	lines = append(lines, objectToLines(payloadTags, pl.Object)...)
+
+	return i.write(ctx, lines)
+}
+
+// SendJoinNotification does nothing.
+func (i *Integration) SendJoinNotification(ctx context.Context, pl integration.JoinNotification) error {
+	return nil
+}
+
+// SendACKNotification does nothing.
+func (i *Integration) SendACKNotification(ctx context.Context, pl integration.ACKNotification) error {
+	return nil
+}
+
+// SendErrorNotification does nothing.
+func (i *Integration) SendErrorNotification(ctx context.Context, pl integration.ErrorNotification) error {
+	return nil
+}
+
+// SendStatusNotification sends a status notification.
+func (i *Integration) SendStatusNotification(ctx context.Context, pl integration.StatusNotification) error {
+	tags := baseTags(pl.ApplicationName, pl.DeviceName, pl.DevEUI, pl.Tags)
+
+	lines := []string{
+		buildLine("device_status_battery", tags, map[string]interface{}{"value": pl.Battery}),
+		buildLine("device_status_battery_level", tags, map[string]interface{}{"value": pl.BatteryLevel}),
+		buildLine("device_status_margin", tags, map[string]interface{}{"value": pl.Margin}),
+	}
+
+	return i.write(ctx, lines)
+}
+
+// SendLocationNotification does nothing.
+func (i *Integration) SendLocationNotification(ctx context.Context, pl integration.LocationNotification) error {
+	return nil
+}
+
+// DataDownChan return nil.
+func (i *Integration) DataDownChan() chan integration.DataDownPayload {
+	return nil
+}
+
+func (i *Integration) write(ctx context.Context, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	sort.Strings(lines)
+	body := strings.Join(lines, "\n")
+
+	req, err := http.NewRequest("POST", i.config.Endpoint, strings.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "new request error")
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	q := url.Values{}
+
+	switch i.config.Version {
+	case "2":
+		q.Set("org", i.config.Organization)
+		q.Set("bucket", i.config.Bucket)
+
+		precision := i.config.Precision
+		if precision == "" {
+			precision = "ns"
+		}
+		q.Set("precision", precision)
+
+		req.Header.Set("Authorization", "Token "+i.config.Token)
+	default:
+		q.Set("db", i.config.DB)
+		if i.config.RetentionPolicyName != "" {
+			q.Set("rp", i.config.RetentionPolicyName)
+		}
+		q.Set("precision", i.config.Precision)
+
+		req.SetBasicAuth(i.config.Username, i.config.Password)
+	}
+
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := i.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("expected 2xx response, got: %d (%s)", resp.StatusCode, string(b))
+	}
+
+	log.WithFields(log.Fields{
+		"lines":  len(lines),
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("integration/influxdb: points written")
+
+	return nil
+}
+
+func baseTags(applicationName, deviceName string, devEUI lorawan.EUI64, extra map[string]string) map[string]string {
+	tags := map[string]string{
+		"application_name": applicationName,
+		"dev_eui":          devEUI.String(),
+		"device_name":      deviceName,
+	}
+	for k, v := range extra {
+		tags[k] = v
+	}
+	return tags
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		out[k] = v
+	}
+	return out
+}
+
+// objectToLines flattens the decoded payload object into one line per
+// leaf value (or per latitude / longitude pair), each prefixed with
+// "device_frmpayload_data_".
+func objectToLines(tags map[string]string, object interface{}) []string {
+	if object == nil {
+		return nil
+	}
+
+	points := map[string]map[string]interface{}{}
+	walkObject("", reflect.ValueOf(object), points)
+
+	var lines []string
+	for suffix, fields := range points {
+		lines = append(lines, buildLine("device_frmpayload_data_"+suffix, tags, fields))
+	}
+	return lines
+}
+
+func walkObject(prefix string, v reflect.Value, points map[string]map[string]interface{}) {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	if !v.IsValid() {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			walkStringKeyedMap(prefix, v, points)
+			return
+		}
+
+		keys := v.MapKeys()
+		sort.Slice(keys, func(a, b int) bool {
+			return fmt.Sprintf("%v", keys[a].Interface()) < fmt.Sprintf("%v", keys[b].Interface())
+		})
+		for _, k := range keys {
+			walkObject(joinPrefix(prefix, fmt.Sprintf("%v", k.Interface())), v.MapIndex(k), points)
+		}
+
+	case reflect.Struct:
+		if isGPSLocation(v) {
+			addGPSLocationPoints(prefix, v, points)
+			return
+		}
+
+		t := v.Type()
+		for n := 0; n < v.NumField(); n++ {
+			f := t.Field(n)
+			if f.PkgPath != "" {
+				continue
+			}
+			walkObject(joinPrefix(prefix, toSnakeCase(f.Name)), v.Field(n), points)
+		}
+
+	default:
+		if prefix == "" {
+			return
+		}
+		points[prefix] = map[string]interface{}{"value": v.Interface()}
+	}
+}
+
+// walkStringKeyedMap handles a map[string]interface{}. A "latitude" /
+// "longitude" pair is merged into a single "location" point (with a
+// geohash field) instead of two independent scalar points.
+func walkStringKeyedMap(prefix string, v reflect.Value, points map[string]map[string]interface{}) {
+	values := map[string]reflect.Value{}
+	for _, k := range v.MapKeys() {
+		values[k.String()] = v.MapIndex(k)
+	}
+
+	lat, hasLat := values["latitude"]
+	lon, hasLon := values["longitude"]
+	if hasLat && hasLon {
+		points[joinPrefix(prefix, "location")] = locationFields(derefFloat(lat), derefFloat(lon))
+		delete(values, "latitude")
+		delete(values, "longitude")
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		walkObject(joinPrefix(prefix, k), values[k], points)
+	}
+}
+
+func isGPSLocation(v reflect.Value) bool {
+	t := v.Type()
+	for _, name := range []string{"Latitude", "Longitude", "Altitude"} {
+		f, ok := t.FieldByName(name)
+		if !ok || f.Type.Kind() != reflect.Float64 {
+			return false
+		}
+	}
+	return true
+}
+
+func addGPSLocationPoints(prefix string, v reflect.Value, points map[string]map[string]interface{}) {
+	lat := v.FieldByName("Latitude").Float()
+	lon := v.FieldByName("Longitude").Float()
+	alt := v.FieldByName("Altitude").Float()
+
+	points[joinPrefix(prefix, "altitude")] = map[string]interface{}{"value": alt}
+	points[joinPrefix(prefix, "location")] = locationFields(lat, lon)
+}
+
+func locationFields(lat, lon float64) map[string]interface{} {
+	return map[string]interface{}{
+		"geohash":   geohash.Encode(lat, lon),
+		"latitude":  lat,
+		"longitude": lon,
+	}
+}
+
+func derefFloat(v reflect.Value) float64 {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	default:
+		return 0
+	}
+}
+
+func joinPrefix(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// toSnakeCase converts an exported Go struct field name (e.g.
+// "GPSLocation") into its line-protocol key form (e.g. "gps_location"),
+// keeping runs of uppercase letters (acronyms) together.
+func toSnakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+
+	for idx, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := idx > 0 && unicode.IsLower(runes[idx-1])
+			nextLower := idx > 0 && idx+1 < len(runes) && unicode.IsLower(runes[idx+1]) && unicode.IsUpper(runes[idx-1])
+			if prevLower || nextLower {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+func buildLine(measurement string, tags map[string]string, fields map[string]interface{}) string {
+	tagKeys := make([]string, 0, len(tags))
+	for k := range tags {
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+
+	tagParts := make([]string, 0, len(tagKeys))
+	for _, k := range tagKeys {
+		tagParts = append(tagParts, fmt.Sprintf("%s=%s", escapeLineProtocol(k), escapeLineProtocol(tags[k])))
+	}
+
+	fieldKeys := make([]string, 0, len(fields))
+	for k := range fields {
+		fieldKeys = append(fieldKeys, k)
+	}
+	sort.Strings(fieldKeys)
+
+	fieldParts := make([]string, 0, len(fieldKeys))
+	for _, k := range fieldKeys {
+		fieldParts = append(fieldParts, fmt.Sprintf("%s=%s", k, formatFieldValue(fields[k])))
+	}
+
+	return fmt.Sprintf("%s,%s %s", measurement, strings.Join(tagParts, ","), strings.Join(fieldParts, ","))
+}
+
+func escapeLineProtocol(s string) string {
+	r := strings.NewReplacer(" ", "\\ ", ",", "\\,", "=", "\\=")
+	return r.Replace(s)
+}
+
+func formatFieldValue(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Interface || rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	case reflect.String:
+		return fmt.Sprintf("%q", rv.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("%di", rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("%di", rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return fmt.Sprintf("%f", rv.Float())
+	default:
+		return fmt.Sprintf("%q", fmt.Sprintf("%v", v))
+	}
+}
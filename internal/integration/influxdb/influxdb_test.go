@@ -368,3 +368,82 @@ device_uplink,application_name=test-app,dev_eui=0102030405060708,device_name=tes
 func TestHandler(t *testing.T) {
 	suite.Run(t, new(HandlerTestSuite))
 }
+
+type HandlerV2TestSuite struct {
+	suite.Suite
+
+	Requests chan *http.Request
+	Server   *httptest.Server
+}
+
+func (ts *HandlerV2TestSuite) SetupSuite() {
+	ts.Requests = make(chan *http.Request, 100)
+
+	httpHandler := testHTTPHandler{
+		requests: ts.Requests,
+	}
+	ts.Server = httptest.NewServer(&httpHandler)
+}
+
+func (ts *HandlerV2TestSuite) TearDownSuite() {
+	ts.Server.Close()
+}
+
+func (ts *HandlerV2TestSuite) TestWrite() {
+	tests := []struct {
+		Name              string
+		Precision         string
+		ExpectedPrecision string
+	}{
+		{
+			Name:              "explicit precision",
+			Precision:         "s",
+			ExpectedPrecision: "s",
+		},
+		{
+			Name:              "default precision",
+			Precision:         "",
+			ExpectedPrecision: "ns",
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			h, err := New(Config{
+				Version:      "2",
+				Endpoint:     ts.Server.URL + "/api/v2/write",
+				Organization: "chirpstack",
+				Bucket:       "device-data",
+				Token:        "secret-token",
+				Precision:    tst.Precision,
+			})
+			assert.NoError(err)
+
+			assert.NoError(h.SendStatusNotification(context.Background(), integration.StatusNotification{
+				ApplicationName: "test-app",
+				DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+				DeviceName:      "test-device",
+				Battery:         123,
+				BatteryLevel:    48.43,
+				Margin:          10,
+			}))
+
+			req := <-ts.Requests
+			assert.Equal("/api/v2/write", req.URL.Path)
+			assert.Equal(url.Values{
+				"org":       []string{"chirpstack"},
+				"bucket":    []string{"device-data"},
+				"precision": []string{tst.ExpectedPrecision},
+			}, req.URL.Query())
+
+			assert.Equal("Token secret-token", req.Header.Get("Authorization"))
+			assert.Equal("text/plain", req.Header.Get("Content-Type"))
+		})
+	}
+}
+
+func TestHandlerV2(t *testing.T) {
+	suite.Run(t, new(HandlerV2TestSuite))
+}